@@ -1,20 +1,45 @@
 package main
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
 
 	"github.com/anyproto/any-sync/util/crypto"
-)
 
-const anytypeMetadataPath = "m/SLIP-0021/anytype/account/metadata"
+	"anytype-decryptor/pkg/cipherreg"
+	"anytype-decryptor/pkg/dagdecrypt"
+	"anytype-decryptor/pkg/keyring"
+	"anytype-decryptor/pkg/passphrase"
+	"anytype-decryptor/pkg/stream"
+	"anytype-decryptor/pkg/validate"
+)
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: decrypt <encrypted-file> <output-file>")
+	streamMode := flag.Bool("stream", false, "decrypt a chunked stream from stdin to stdout instead of reading a whole file into memory")
+	pathFlag := flag.String("path", "", "force a specific SLIP-0021 derivation path instead of walking the known registry (e.g. m/SLIP-0021/anytype/account/metadata)")
+	spaceFlag := flag.String("space", "", "space ID to include the per-space SLIP-0021 path for (see pkg/keyring.Options.SpaceID)")
+	objectFlag := flag.String("object", "", "object ID to include the per-object read/edit SLIP-0021 paths for (see pkg/keyring.Options.ObjectID)")
+	fileFlag := flag.String("file", "", "file CID to include the per-file SLIP-0021 path for (see pkg/keyring.Options.FileCID)")
+	rootFlag := flag.String("root", "", "restrict derivation to one root key, identity or masterkey (required alongside -path for -stream, since a drained stdin can't be retried against a second key)")
+	cidFlag := flag.String("cid", "", "decrypt a file stored as a DAG-PB/UnixFS block DAG rooted at this CIDv1 instead of a single encrypted file")
+	repoFlag := flag.String("repo", "", "local IPFS flatfs repo path (<repo>/blocks) to fetch -cid blocks from; defaults to -gateway")
+	gatewayFlag := flag.String("gateway", "https://ipfs.io", "IPFS HTTP gateway to fetch -cid blocks from when -repo isn't set")
+	passphraseFlag := flag.String("passphrase", "", "derive an additional key from this export passphrase instead of (or alongside) the BIP39 mnemonic")
+	kdfFlag := flag.String("kdf", string(passphrase.KDFScrypt), "KDF to use with -passphrase: scrypt or argon2id")
+	flag.Parse()
+	args := flag.Args()
+
+	if !*streamMode && *cidFlag == "" && len(args) < 2 {
+		fmt.Println("Usage: decrypt [-passphrase <pass> [-kdf scrypt|argon2id]] <encrypted-file> <output-file>")
+		fmt.Println("       decrypt -stream < encrypted.stream > decrypted.stream")
+		fmt.Println("       decrypt -cid <cidv1> [-repo <path> | -gateway <url>] <output-file>")
 		return
 	}
 
@@ -28,70 +53,87 @@ func main() {
 		return
 	}
 
-	// Read encrypted file
-	encryptedData, err := ioutil.ReadFile(os.Args[1])
-	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
+	if *rootFlag != "" && !strings.EqualFold(*rootFlag, "identity") && !strings.EqualFold(*rootFlag, "masterkey") {
+		fmt.Printf("Error: -root must be \"identity\" or \"masterkey\", got %q\n", *rootFlag)
 		return
 	}
 
-	fmt.Printf("File size: %d bytes\n", len(encryptedData))
-	fmt.Printf("First 32 bytes: %x\n", encryptedData[:min(32, len(encryptedData))])
+	opts := keyring.Options{SpaceID: *spaceFlag, ObjectID: *objectFlag, FileCID: *fileFlag}
+	if *cidFlag != "" && opts.FileCID == "" {
+		// The file's own CID is the natural FileCID when one wasn't given explicitly.
+		opts.FileCID = *cidFlag
+	}
 
-	// Try multiple decryption approaches
-	success := false
+	if *cidFlag == "" && *streamMode && (*pathFlag == "" || *rootFlag == "") {
+		fmt.Println("Error: -stream requires both -path and -root, since stdin can't be rewound to retry a second candidate key.")
+		return
+	}
 
-	// 1. Try deriving symmetric keys from private keys
-	var keys []crypto.SymKey
+	keys, labels := candidateKeys(derivationResult, *pathFlag, opts, *rootFlag)
 
-	// Try deriving account metadata key from Identity
-	if accountKey, err := deriveAccountEncKey(derivationResult.Identity); err == nil {
-		keys = append(keys, accountKey)
+	if *cidFlag != "" {
+		runCIDMode(*cidFlag, *repoFlag, *gatewayFlag, keys, labels, args)
+		return
 	}
 
-	// Try deriving from master key
-	if masterEncKey, err := deriveAccountEncKey(derivationResult.MasterKey); err == nil {
-		keys = append(keys, masterEncKey)
+	if *streamMode {
+		if len(keys) != 1 {
+			fmt.Printf("Error: -stream needs exactly one candidate key (got %d) even with -path and -root set; check that both resolved to a single known root.\n", len(keys))
+			return
+		}
+		runStreamMode(keys[0], labels[0])
+		return
 	}
 
-	// Try CFB decryption with different keys
-	for i, key := range keys {
-		fmt.Printf("\nTrying key %d (CFB with zero IV)...\n", i+1)
-		if decryptedData, err := tryDecryptCFB(encryptedData, key); err == nil {
+	// Read encrypted file
+	encryptedData, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Printf("Error reading file: %v\n", err)
+		return
+	}
+
+	fmt.Printf("File size: %d bytes\n", len(encryptedData))
+	fmt.Printf("First 32 bytes: %x\n", encryptedData[:min(32, len(encryptedData))])
+
+	candidates := trialCandidates(keys, labels, encryptedData, *passphraseFlag, passphrase.KDF(*kdfFlag))
+
+	// Try every (candidate, scheme) pair, stopping at the first one that
+	// produces a fully validated protobuf message.
+	success := false
+	for _, candidate := range candidates {
+		for _, scheme := range cipherreg.All() {
+			fmt.Printf("\nTrying key %s with %s...\n", candidate.Label, scheme.Name())
+			decryptedData, err := scheme.Decrypt(candidate.Ciphertext, candidate.Key)
+			if err != nil {
+				fmt.Printf("✗ Failed with key %s (%s): %v\n", candidate.Label, scheme.Name(), err)
+				continue
+			}
+			schema, err := validate.Validate(decryptedData)
+			if err != nil {
+				fmt.Printf("✗ Failed with key %s (%s): validation failed: %v\n", candidate.Label, scheme.Name(), err)
+				continue
+			}
 			success = true
-			fmt.Printf("✓ Decryption successful with key %d (CFB)!\n", i+1)
-			if err := writeDecryptedData(os.Args[2], decryptedData); err != nil {
+			if schema != "" {
+				fmt.Printf("✓ Decryption successful with key %s (%s), matched schema %s!\n", candidate.Label, scheme.Name(), schema)
+			} else {
+				fmt.Printf("✓ Decryption successful with key %s (%s), schema unrecognized!\n", candidate.Label, scheme.Name())
+			}
+			if err := writeDecryptedData(args[1], decryptedData); err != nil {
 				fmt.Printf("Error writing file: %v\n", err)
 				return
 			}
 			break
-		} else {
-			fmt.Printf("✗ Failed with key %d (CFB): %v\n", i+1, err)
 		}
-	}
-
-	// Try direct decryption with symmetric keys
-	if !success {
-		for i, key := range keys {
-			fmt.Printf("\nTrying key %d (Direct decryption)...\n", i+1)
-			if decryptedData, err := key.Decrypt(encryptedData); err == nil {
-				success = true
-				fmt.Printf("✓ Decryption successful with key %d (Direct)!\n", i+1)
-				if err := writeDecryptedData(os.Args[2], decryptedData); err != nil {
-					fmt.Printf("Error writing file: %v\n", err)
-					return
-				}
-				break
-			} else {
-				fmt.Printf("✗ Failed with key %d (Direct): %v\n", i+1, err)
-			}
+		if success {
+			break
 		}
 	}
 
 	if !success {
 		fmt.Println("\n❌ All decryption attempts failed. The file might use a different encryption scheme or key derivation.")
 		fmt.Println("\nPossible reasons:")
-		fmt.Println("- File might be encrypted with a space-specific key")
+		fmt.Println("- File might need a per-space/object/file path not yet known to the keyring registry (try -path)")
 		fmt.Println("- File might use a different encryption algorithm")
 		fmt.Println("- File might be a different type of Anytype data")
 		fmt.Println("- File might require additional IPFS/DAG context")
@@ -100,92 +142,148 @@ func main() {
 	}
 }
 
-func deriveAccountEncKey(accKey crypto.PrivKey) (crypto.SymKey, error) {
-	raw, err := accKey.Raw()
-	if err != nil {
-		return nil, err
-	}
-	return crypto.DeriveSymmetricKey(raw, anytypeMetadataPath)
+// trialCandidate pairs a symmetric key with the ciphertext it should be
+// tried against: usually the whole file, but a passphrase-derived key
+// strips its salt header off first.
+type trialCandidate struct {
+	Label      string
+	Key        crypto.SymKey
+	Ciphertext []byte
 }
 
-func tryDecryptCFB(encryptedData []byte, key crypto.SymKey) ([]byte, error) {
-	// Get raw key
-	rawKey, err := key.Raw()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get raw key: %w", err)
+// trialCandidates builds the full set of (key, ciphertext) pairs worth
+// trial-decrypting: one per mnemonic-derived key in keys/labels against
+// the whole file, plus one more derived from passphrase (if set) against
+// the file with its leading passphrase.SaltSize-byte salt header removed.
+func trialCandidates(keys []crypto.SymKey, labels []string, encryptedData []byte, pass string, kdf passphrase.KDF) []trialCandidate {
+	candidates := make([]trialCandidate, 0, len(keys)+1)
+	for i, key := range keys {
+		candidates = append(candidates, trialCandidate{Label: labels[i], Key: key, Ciphertext: encryptedData})
 	}
-	
-	if len(rawKey) != 32 {
-		return nil, fmt.Errorf("invalid key length: expected 32, got %d", len(rawKey))
+
+	if pass != "" {
+		if len(encryptedData) < passphrase.SaltSize {
+			fmt.Printf("✗ File too short to carry a %d-byte passphrase salt header\n", passphrase.SaltSize)
+			return candidates
+		}
+		salt := encryptedData[:passphrase.SaltSize]
+		key, err := passphrase.DeriveKey(pass, salt, kdf)
+		if err != nil {
+			fmt.Printf("✗ Failed to derive passphrase key: %v\n", err)
+			return candidates
+		}
+		candidates = append(candidates, trialCandidate{
+			Label:      fmt.Sprintf("passphrase:%s", kdf),
+			Key:        key,
+			Ciphertext: encryptedData[passphrase.SaltSize:],
+		})
 	}
-	
-	// Create AES cipher
-	block, err := aes.NewCipher(rawKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+
+	return candidates
+}
+
+// candidateKeys derives every symmetric key worth trial-decrypting with.
+// With forcedPath empty it walks the keyring registry for opts against
+// both the Identity and MasterKey roots; with forcedPath set it derives
+// only that one SLIP-0021 path from each root. rootFilter, if set to
+// "identity" or "masterkey" (case-insensitive), restricts derivation to
+// that single root instead of trying both. The returned labels are
+// parallel to keys and describe which (root, path) pair produced each one.
+func candidateKeys(derivationResult crypto.DerivationResult, forcedPath string, opts keyring.Options, rootFilter string) (keys []crypto.SymKey, labels []string) {
+	roots := []struct {
+		name string
+		key  crypto.PrivKey
+	}{
+		{"Identity", derivationResult.Identity},
+		{"MasterKey", derivationResult.MasterKey},
 	}
-	
-	// Use zero IV as in the anytype-heart codebase
-	iv := make([]byte, aes.BlockSize)
-	
-	// Create CFB decrypter
-	stream := cipher.NewCFBDecrypter(block, iv)
-	
-	// Decrypt data
-	decryptedData := make([]byte, len(encryptedData))
-	stream.XORKeyStream(decryptedData, encryptedData)
-	
-	// Basic validation - check if it looks like protobuf
-	if err := validateDecryption(decryptedData); err != nil {
-		return nil, fmt.Errorf("decryption validation failed: %w", err)
+
+	for _, root := range roots {
+		if rootFilter != "" && !strings.EqualFold(rootFilter, root.name) {
+			continue
+		}
+		if forcedPath != "" {
+			key, err := keyring.DeriveForPath(root.key, forcedPath)
+			if err != nil {
+				continue
+			}
+			keys = append(keys, key)
+			labels = append(labels, fmt.Sprintf("%s:%s", root.name, forcedPath))
+			continue
+		}
+
+		candidates, err := keyring.Derive(root.key, opts)
+		if err != nil {
+			continue
+		}
+		for _, c := range candidates {
+			keys = append(keys, c.Key)
+			labels = append(labels, fmt.Sprintf("%s:%s", root.name, c.Label))
+		}
 	}
-	
-	return decryptedData, nil
+	return keys, labels
 }
 
-func validateDecryption(data []byte) error {
-	if len(data) == 0 {
-		return fmt.Errorf("empty data")
+// runCIDMode decrypts a file stored as a DAG-PB/UnixFS block DAG (see
+// pkg/dagdecrypt) rooted at cidStr, fetching blocks from a local flatfs
+// repo if repoPath is set or from the IPFS gateway otherwise. Like the
+// whole-file path, it trial-decrypts against every candidate keyring key
+// since there's no way to tell up front which derivation the file key
+// came from.
+func runCIDMode(cidStr, repoPath, gateway string, keys []crypto.SymKey, labels []string, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: decrypt -cid <cidv1> [-repo <path> | -gateway <url>] <output-file>")
+		return
 	}
-	
-	// Check if it looks like protobuf data
-	// Protobuf messages start with field tags where:
-	// - bits 0-2 are wire type (0-5 are valid)
-	// - bits 3+ are field number (must be > 0)
-	firstByte := data[0]
-	wireType := firstByte & 0x07
-	fieldNumber := firstByte >> 3
-	
-	if wireType > 5 {
-		return fmt.Errorf("invalid protobuf wire type: %d", wireType)
+
+	root, err := cid.Decode(cidStr)
+	if err != nil {
+		fmt.Printf("Error parsing CID: %v\n", err)
+		return
 	}
-	
-	if fieldNumber == 0 {
-		return fmt.Errorf("invalid protobuf field number: 0")
+
+	var fetcher dagdecrypt.Fetcher
+	if repoPath != "" {
+		fetcher = dagdecrypt.FlatfsFetcher{Dir: filepath.Join(repoPath, "blocks")}
+	} else {
+		fetcher = dagdecrypt.GatewayFetcher{BaseURL: gateway}
 	}
-	
-	// Additional check: look for printable characters or null bytes
-	nullCount := 0
-	printableCount := 0
-	for i, b := range data {
-		if i > 100 { // Only check first 100 bytes
-			break
-		}
-		if b == 0 {
-			nullCount++
+
+	for i, key := range keys {
+		fmt.Printf("Trying key %s...\n", labels[i])
+		decrypted, err := dagdecrypt.Decrypt(root, fetcher, key)
+		if err != nil {
+			fmt.Printf("✗ Failed with key %s: %v\n", labels[i], err)
+			continue
 		}
-		if b >= 32 && b <= 126 {
-			printableCount++
+		if err := writeDecryptedData(args[0], decrypted); err != nil {
+			fmt.Printf("Error writing file: %v\n", err)
+			return
 		}
+		fmt.Printf("\n✅ File decrypted successfully with key %s!\n", labels[i])
+		return
 	}
-	
-	// If too many nulls or no printable chars, might be gibberish
-	checkLen := min(100, len(data))
-	if nullCount > checkLen/2 && printableCount == 0 {
-		return fmt.Errorf("decrypted data appears to be random bytes")
+
+	fmt.Println("\n❌ Failed to decrypt DAG with any candidate key.")
+}
+
+// runStreamMode decrypts a chunked stream (see pkg/stream) from stdin to
+// stdout using key. Unlike the whole-file path, stdin can't be rewound,
+// so there's no way to retry a second key once bytes have already been
+// consumed from it; callers must resolve exactly one candidate key
+// (via -path and -root) before calling this.
+func runStreamMode(key crypto.SymKey, label string) {
+	fmt.Fprintf(os.Stderr, "Trying key %s (stream)...\n", label)
+	sr, err := stream.NewReader(os.Stdin, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Failed to init stream reader with key %s: %v\n", label, err)
+		return
 	}
-	
-	return nil
+	if _, err := io.Copy(os.Stdout, sr); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Failed with key %s (stream): %v\n", label, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "✓ Stream decrypted successfully with key %s!\n", label)
 }
 
 func writeDecryptedData(filename string, data []byte) error {
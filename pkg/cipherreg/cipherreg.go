@@ -0,0 +1,155 @@
+// Package cipherreg is a small registry of trial-decryption schemes.
+//
+// The decryptor used to hardcode a single AES-CFB/zero-IV pass. Anytype
+// and the libp2p/gocryptfs code it borrows conventions from actually use
+// several different on-disk encodings, so this package lets the main
+// trial-decryption loop iterate over (key, scheme) pairs instead: adding
+// a newly observed format is a matter of registering a new Scheme here,
+// not editing main.
+package cipherreg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/anyproto/any-sync/util/crypto"
+)
+
+// Scheme decrypts ciphertext produced by one specific on-disk encoding.
+type Scheme interface {
+	// Name identifies the scheme in diagnostic output.
+	Name() string
+	// Decrypt decrypts ct with key, returning an error if ct doesn't fit
+	// the scheme's expected shape (e.g. too short for its nonce or tag).
+	Decrypt(ct []byte, key crypto.SymKey) ([]byte, error)
+}
+
+var registry []Scheme
+
+// Register adds a Scheme to the set returned by All. Called from this
+// package's init for the built-ins; other packages can call it too to add
+// formats without touching the trial-decryption loop.
+func Register(s Scheme) {
+	registry = append(registry, s)
+}
+
+// All returns every registered scheme, in registration order.
+func All() []Scheme {
+	return registry
+}
+
+func init() {
+	Register(aesCFBZeroIV{})
+	Register(aesGCMPrependedNonce{})
+	Register(xchacha20poly1305PrependedNonce{})
+	Register(aesCTRPrependedIV{})
+}
+
+func rawKey(key crypto.SymKey) ([]byte, error) {
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get raw key: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("invalid key length: expected 32, got %d", len(raw))
+	}
+	return raw, nil
+}
+
+// aesCFBZeroIV is the original scheme: AES-256-CFB with an all-zero IV, as
+// seen in the anytype-heart codebase.
+type aesCFBZeroIV struct{}
+
+func (aesCFBZeroIV) Name() string { return "AES-256-CFB/zero-IV" }
+
+func (aesCFBZeroIV) Decrypt(ct []byte, key crypto.SymKey) ([]byte, error) {
+	raw, err := rawKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	stream := cipher.NewCFBDecrypter(block, iv)
+	pt := make([]byte, len(ct))
+	stream.XORKeyStream(pt, ct)
+	return pt, nil
+}
+
+// aesGCMPrependedNonce is AES-256-GCM with the 12-byte nonce prepended to
+// the ciphertext, the format any-sync's own AESKey.Encrypt produces.
+type aesGCMPrependedNonce struct{}
+
+func (aesGCMPrependedNonce) Name() string { return "AES-256-GCM/prepended-nonce" }
+
+func (aesGCMPrependedNonce) Decrypt(ct []byte, key crypto.SymKey) ([]byte, error) {
+	raw, err := rawKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init GCM: %w", err)
+	}
+	if len(ct) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short for GCM nonce")
+	}
+	nonce, body := ct[:aead.NonceSize()], ct[aead.NonceSize():]
+	return aead.Open(nil, nonce, body, nil)
+}
+
+// xchacha20poly1305PrependedNonce is XChaCha20-Poly1305 with the 24-byte
+// extended nonce prepended to the ciphertext.
+type xchacha20poly1305PrependedNonce struct{}
+
+func (xchacha20poly1305PrependedNonce) Name() string { return "XChaCha20-Poly1305/prepended-nonce" }
+
+func (xchacha20poly1305PrependedNonce) Decrypt(ct []byte, key crypto.SymKey) ([]byte, error) {
+	raw, err := rawKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init XChaCha20-Poly1305: %w", err)
+	}
+	if len(ct) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short for XChaCha20-Poly1305 nonce")
+	}
+	nonce, body := ct[:aead.NonceSize()], ct[aead.NonceSize():]
+	return aead.Open(nil, nonce, body, nil)
+}
+
+// aesCTRPrependedIV is AES-256-CTR with the 16-byte IV prepended to the
+// ciphertext, the format gocryptfs content encryption uses.
+type aesCTRPrependedIV struct{}
+
+func (aesCTRPrependedIV) Name() string { return "AES-256-CTR/prepended-IV" }
+
+func (aesCTRPrependedIV) Decrypt(ct []byte, key crypto.SymKey) ([]byte, error) {
+	raw, err := rawKey(key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	if len(ct) < aes.BlockSize {
+		return nil, fmt.Errorf("ciphertext too short for CTR IV")
+	}
+	iv, body := ct[:aes.BlockSize], ct[aes.BlockSize:]
+	stream := cipher.NewCTR(block, iv)
+	pt := make([]byte, len(body))
+	stream.XORKeyStream(pt, body)
+	return pt, nil
+}
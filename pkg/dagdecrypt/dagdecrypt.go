@@ -0,0 +1,252 @@
+// Package dagdecrypt reassembles Anytype file blocks stored as IPLD
+// DAG-PB/UnixFS nodes: the node structure (links, sizes) travels in the
+// clear the way IPFS always stores it, but each node's Data payload is
+// encrypted with the same SLIP-0021 file key a caller derives from the
+// keyring (see pkg/keyring, Options.FileCID) rather than anything tied to
+// the node's own CID — the CID is the hash of the stored ciphertext block,
+// so keying off it would let anyone holding the block derive its own key.
+// Decrypt walks the DAG from a root CID, decrypting each node's payload
+// with the supplied key and concatenating them in link order to recover
+// the plaintext file.
+package dagdecrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/anyproto/any-sync/util/crypto"
+)
+
+// Link is one child reference out of a DAG-PB node.
+type Link struct {
+	CID  cid.Cid
+	Name string
+	Size uint64
+}
+
+// Node is a parsed DAG-PB node: an (encrypted) payload plus links to
+// child blocks that continue the file.
+type Node struct {
+	Data  []byte
+	Links []Link
+}
+
+// ParseNode decodes a DAG-PB encoded block:
+//
+//	message PBLink { optional bytes Hash = 1; optional string Name = 2; optional uint64 Tsize = 3; }
+//	message PBNode { optional bytes Data = 1; repeated PBLink Links = 2; }
+func ParseNode(raw []byte) (*Node, error) {
+	node := &Node{}
+	b := raw
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, fmt.Errorf("dagdecrypt: invalid tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch num {
+		case 1: // Data
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, fmt.Errorf("dagdecrypt: invalid Data field: %w", protowire.ParseError(n))
+			}
+			node.Data = append([]byte(nil), v...)
+			b = b[n:]
+		case 2: // Links
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, fmt.Errorf("dagdecrypt: invalid Links field: %w", protowire.ParseError(n))
+			}
+			link, err := parseLink(v)
+			if err != nil {
+				return nil, err
+			}
+			node.Links = append(node.Links, link)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, fmt.Errorf("dagdecrypt: invalid field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	return node, nil
+}
+
+func parseLink(raw []byte) (Link, error) {
+	var link Link
+	var hash []byte
+	b := raw
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return link, fmt.Errorf("dagdecrypt: invalid link tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch num {
+		case 1: // Hash
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return link, fmt.Errorf("dagdecrypt: invalid link Hash: %w", protowire.ParseError(n))
+			}
+			hash = v
+			b = b[n:]
+		case 2: // Name
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return link, fmt.Errorf("dagdecrypt: invalid link Name: %w", protowire.ParseError(n))
+			}
+			link.Name = v
+			b = b[n:]
+		case 3: // Tsize
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return link, fmt.Errorf("dagdecrypt: invalid link Tsize: %w", protowire.ParseError(n))
+			}
+			link.Size = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return link, fmt.Errorf("dagdecrypt: invalid link field %d: %w", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	c, err := cid.Cast(hash)
+	if err != nil {
+		return link, fmt.Errorf("dagdecrypt: invalid link CID: %w", err)
+	}
+	link.CID = c
+	return link, nil
+}
+
+// Fetcher retrieves the raw DAG-PB block addressed by a CID.
+type Fetcher interface {
+	Fetch(c cid.Cid) ([]byte, error)
+}
+
+// FlatfsFetcher reads blocks out of a local go-ipfs/kubo flatfs
+// blockstore rooted at Dir (typically "<ipfs-repo>/blocks"), using the
+// default next-to-last-two-base32-char sharding.
+type FlatfsFetcher struct {
+	Dir string
+}
+
+func (f FlatfsFetcher) Fetch(c cid.Cid) ([]byte, error) {
+	key := strings.ToUpper(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(c.Hash()))
+	shard := key
+	if len(shard) >= 3 {
+		shard = shard[len(shard)-3 : len(shard)-1]
+	}
+	data, err := os.ReadFile(filepath.Join(f.Dir, shard, key+".data"))
+	if err != nil {
+		return nil, fmt.Errorf("dagdecrypt: flatfs read %s: %w", c, err)
+	}
+	return data, nil
+}
+
+// GatewayFetcher fetches blocks from an IPFS HTTP gateway's raw block API.
+type GatewayFetcher struct {
+	BaseURL string
+}
+
+func (f GatewayFetcher) Fetch(c cid.Cid) ([]byte, error) {
+	url := strings.TrimRight(f.BaseURL, "/") + "/ipfs/" + c.String() + "?format=raw"
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("dagdecrypt: gateway fetch %s: %w", c, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dagdecrypt: gateway fetch %s: status %s", c, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// decryptCFB mirrors tryDecryptCFB in main: AES-CFB with a zero IV, the
+// scheme Anytype file blocks use.
+func decryptCFB(ciphertext, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("dagdecrypt: failed to create cipher: %w", err)
+	}
+	iv := make([]byte, aes.BlockSize)
+	stream := cipher.NewCFBDecrypter(block, iv)
+	plaintext := make([]byte, len(ciphertext))
+	stream.XORKeyStream(plaintext, ciphertext)
+	return plaintext, nil
+}
+
+// maxDepth and maxNodes bound how far Decrypt will walk an untrusted DAG,
+// guarding against a cyclic or adversarially deep/wide tree served by a
+// -gateway or -repo that isn't trusted.
+const (
+	maxDepth = 1024
+	maxNodes = 1 << 20
+)
+
+// Decrypt fetches the DAG rooted at root, decrypts every node's payload
+// with key and reassembles them in link order into the plaintext file.
+func Decrypt(root cid.Cid, fetcher Fetcher, key crypto.SymKey) ([]byte, error) {
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("dagdecrypt: failed to get raw key: %w", err)
+	}
+
+	var out []byte
+	seen := make(map[string]bool)
+	nodes := 0
+	if err := walk(root, fetcher, raw, 0, seen, &nodes, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func walk(c cid.Cid, fetcher Fetcher, key []byte, depth int, seen map[string]bool, nodes *int, out *[]byte) error {
+	if depth > maxDepth {
+		return fmt.Errorf("dagdecrypt: DAG deeper than %d links, aborting", maxDepth)
+	}
+	if seen[c.KeyString()] {
+		return fmt.Errorf("dagdecrypt: cycle detected at block %s", c)
+	}
+	*nodes++
+	if *nodes > maxNodes {
+		return fmt.Errorf("dagdecrypt: DAG has more than %d blocks, aborting", maxNodes)
+	}
+	seen[c.KeyString()] = true
+
+	raw, err := fetcher.Fetch(c)
+	if err != nil {
+		return err
+	}
+	node, err := ParseNode(raw)
+	if err != nil {
+		return fmt.Errorf("dagdecrypt: parse block %s: %w", c, err)
+	}
+	if len(node.Data) > 0 {
+		plain, err := decryptCFB(node.Data, key)
+		if err != nil {
+			return fmt.Errorf("dagdecrypt: decrypt block %s: %w", c, err)
+		}
+		*out = append(*out, plain...)
+	}
+	for _, link := range node.Links {
+		if err := walk(link.CID, fetcher, key, depth+1, seen, nodes, out); err != nil {
+			return err
+		}
+	}
+	delete(seen, c.KeyString())
+	return nil
+}
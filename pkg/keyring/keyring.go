@@ -0,0 +1,109 @@
+// Package keyring enumerates the SLIP-0021 derivation paths Anytype uses
+// across its key hierarchy and derives the corresponding symmetric keys
+// from a root private key's raw seed.
+//
+// The original decryptor only ever tried the account metadata path, which
+// is why it could decrypt account-level data but nothing space- or
+// file-scoped. This package gives the trial-decryption loop the rest of
+// the hierarchy to walk automatically, plus an escape hatch (DeriveForPath)
+// for forcing a single label the registry doesn't (yet) know about.
+package keyring
+
+import (
+	"fmt"
+
+	"github.com/anyproto/any-sync/util/crypto"
+)
+
+// Fixed SLIP-0021 paths that don't need any external ID to derive.
+const (
+	// AccountMetadataPath is the path the original decryptor hardcoded.
+	AccountMetadataPath = "m/SLIP-0021/anytype/account/metadata"
+
+	// SpacePath mirrors any-sync's own default space key derivation.
+	SpacePath = crypto.AnysyncSpacePath
+)
+
+// Templated paths that are only meaningful once an external ID is known.
+const (
+	spacePathFmt      = "m/SLIP-0021/anytype/space/%s"
+	objectReadPathFmt = "m/SLIP-0021/anytype/object/%s/read"
+	objectEditPathFmt = "m/SLIP-0021/anytype/object/%s/edit"
+	filePathFmt       = "m/SLIP-0021/anytype/file/%s"
+)
+
+// Options narrows which templated paths get tried: per-space, per-object
+// and per-file paths are parameterized by an ID the caller may not have.
+// Leaving a field empty skips the paths that need it.
+type Options struct {
+	SpaceID  string
+	ObjectID string
+	FileCID  string
+}
+
+// PathSpec names one SLIP-0021 path in the registry.
+type PathSpec struct {
+	Label string
+	Path  string
+}
+
+// Candidate is a derived key ready to be trial-decrypted against.
+type Candidate struct {
+	Label string
+	Path  string
+	Key   crypto.SymKey
+}
+
+// Paths returns every SLIP-0021 path worth trying for opts: the fixed
+// account and space paths, plus any templated per-space, per-object or
+// per-file path whose ID was supplied.
+func Paths(opts Options) []PathSpec {
+	paths := []PathSpec{
+		{Label: "account-metadata", Path: AccountMetadataPath},
+		{Label: "space", Path: SpacePath},
+	}
+	if opts.SpaceID != "" {
+		paths = append(paths, PathSpec{Label: "space-id", Path: fmt.Sprintf(spacePathFmt, opts.SpaceID)})
+	}
+	if opts.ObjectID != "" {
+		paths = append(paths,
+			PathSpec{Label: "object-read", Path: fmt.Sprintf(objectReadPathFmt, opts.ObjectID)},
+			PathSpec{Label: "object-edit", Path: fmt.Sprintf(objectEditPathFmt, opts.ObjectID)},
+		)
+	}
+	if opts.FileCID != "" {
+		paths = append(paths, PathSpec{Label: "file", Path: fmt.Sprintf(filePathFmt, opts.FileCID)})
+	}
+	return paths
+}
+
+// Derive derives the symmetric key for every applicable path in opts from
+// rootKey's raw bytes. A path that fails to derive is skipped rather than
+// failing the whole call, since callers want "whatever worked".
+func Derive(rootKey crypto.PrivKey, opts Options) ([]Candidate, error) {
+	raw, err := rootKey.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get raw key: %w", err)
+	}
+
+	var candidates []Candidate
+	for _, p := range Paths(opts) {
+		key, err := crypto.DeriveSymmetricKey(raw, p.Path)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, Candidate{Label: p.Label, Path: p.Path, Key: key})
+	}
+	return candidates, nil
+}
+
+// DeriveForPath derives a single symmetric key for an explicit SLIP-0021
+// path, bypassing the registry in Paths. This backs the -path flag so a
+// label this package doesn't know about yet can still be forced.
+func DeriveForPath(rootKey crypto.PrivKey, path string) (crypto.SymKey, error) {
+	raw, err := rootKey.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get raw key: %w", err)
+	}
+	return crypto.DeriveSymmetricKey(raw, path)
+}
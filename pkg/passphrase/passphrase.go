@@ -0,0 +1,69 @@
+// Package passphrase derives a symmetric decryption key from a user
+// passphrase instead of the BIP39 mnemonic, for Anytype backups protected
+// by an export password rather than (or in addition to) the 12-word
+// phrase. It mirrors the KDF layer age and gocryptfs use for passphrase
+// recipients: scrypt or Argon2id over the passphrase and a salt carried
+// in the file header.
+package passphrase
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/anyproto/any-sync/util/crypto"
+)
+
+// SaltSize is the length of the salt expected at the start of a
+// passphrase-protected file's header.
+const SaltSize = 16
+
+// KDF selects which key derivation function DeriveKey uses.
+type KDF string
+
+const (
+	KDFScrypt   KDF = "scrypt"
+	KDFArgon2id KDF = "argon2id"
+)
+
+// scrypt parameters: N=2^17, r=8, p=1.
+const (
+	scryptN = 1 << 17
+	scryptR = 8
+	scryptP = 1
+)
+
+// Argon2id parameters: t=3, m=64MiB, p=1.
+const (
+	argon2Time      = 3
+	argon2MemoryKiB = 64 * 1024
+	argon2Threads   = 1
+)
+
+const keySize = 32
+
+// DeriveKey derives a 32-byte symmetric key from passphrase and salt
+// using kdf. salt must be SaltSize bytes, as read from the first
+// SaltSize bytes of the encrypted file's header.
+func DeriveKey(passphrase string, salt []byte, kdf KDF) (crypto.SymKey, error) {
+	if len(salt) != SaltSize {
+		return nil, fmt.Errorf("passphrase: expected %d-byte salt, got %d", SaltSize, len(salt))
+	}
+
+	var raw []byte
+	switch kdf {
+	case KDFScrypt:
+		var err error
+		raw, err = scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+		if err != nil {
+			return nil, fmt.Errorf("passphrase: scrypt: %w", err)
+		}
+	case KDFArgon2id:
+		raw = argon2.IDKey([]byte(passphrase), salt, argon2Time, argon2MemoryKiB, argon2Threads, keySize)
+	default:
+		return nil, fmt.Errorf("passphrase: unknown kdf %q (want %q or %q)", kdf, KDFScrypt, KDFArgon2id)
+	}
+
+	return crypto.UnmarshallAESKey(raw)
+}
@@ -0,0 +1,202 @@
+// Package stream implements a chunked, authenticated encryption format for
+// Anytype blobs that are too large to decrypt in a single in-memory pass.
+//
+// It follows the same shape as age's STREAM construction: the file is split
+// into fixed-size chunks, each sealed independently with ChaCha20-Poly1305
+// under a nonce built from an incrementing counter plus a one-byte flag that
+// marks the final chunk. Binding the "is this the last chunk" bit into the
+// nonce means an attacker who truncates the ciphertext after an interior
+// chunk cannot produce a stream that decrypts cleanly: the reader only
+// accepts EOF immediately after a chunk sealed with the final-chunk nonce.
+package stream
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/anyproto/any-sync/util/crypto"
+)
+
+// ChunkSize is the amount of plaintext sealed into each interior chunk.
+const ChunkSize = 64 * 1024
+
+const (
+	nonceSize   = chacha20poly1305.NonceSize // 12 bytes: 88-bit counter + 1-byte flag
+	tagSize     = chacha20poly1305.Overhead
+	cipherChunk = ChunkSize + tagSize
+
+	flagInterior byte = 0x00
+	flagFinal    byte = 0x01
+)
+
+var hkdfInfo = []byte("anytype-decryptor stream payload key v1")
+
+// derivePayloadKey expands the metadata key into a 32-byte ChaCha20-Poly1305
+// key via HKDF, so the stream cipher never uses the metadata key directly.
+func derivePayloadKey(key crypto.SymKey) ([]byte, error) {
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get raw key: %w", err)
+	}
+	payloadKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, raw, nil, hkdfInfo), payloadKey); err != nil {
+		return nil, fmt.Errorf("failed to derive payload key: %w", err)
+	}
+	return payloadKey, nil
+}
+
+func buildNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	// 88-bit big-endian counter occupies nonce[0:11]; counter values never
+	// approach 2^64, so only the low 8 bytes are ever non-zero.
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = flagFinal
+	} else {
+		nonce[11] = flagInterior
+	}
+	return nonce
+}
+
+// reader decrypts a chunked stream produced by a Writer.
+type reader struct {
+	src      *bufio.Reader
+	aead     cipher.AEAD
+	counter  uint64
+	pending  []byte // decrypted plaintext not yet returned to the caller
+	finished bool
+	err      error
+}
+
+// NewReader wraps r, returning a Reader that decrypts the chunked stream
+// format written by NewWriter using a payload key derived from key.
+func NewReader(r io.Reader, key crypto.SymKey) (io.Reader, error) {
+	payloadKey, err := derivePayloadKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init chacha20poly1305: %w", err)
+	}
+	return &reader{src: bufio.NewReaderSize(r, cipherChunk), aead: aead}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.finished {
+			return 0, io.EOF
+		}
+		if err := r.readChunk(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *reader) readChunk() error {
+	buf := make([]byte, cipherChunk)
+	n, err := io.ReadFull(r.src, buf)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		buf = buf[:n]
+	case err != nil:
+		return err
+	default:
+		// A full chunk was read; peek ahead to see whether the stream ends
+		// here, which tells us whether this chunk was sealed as final.
+		if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+			// fall through with the full chunk, treated as final below
+		} else if peekErr != nil {
+			return peekErr
+		} else {
+			return r.decryptChunk(buf, false)
+		}
+	}
+
+	return r.decryptChunk(buf, true)
+}
+
+func (r *reader) decryptChunk(ciphertext []byte, last bool) error {
+	nonce := buildNonce(r.counter, last)
+	plaintext, err := r.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return fmt.Errorf("stream: chunk %d failed authentication (corrupt or truncated stream): %w", r.counter, err)
+	}
+	r.counter++
+	r.pending = plaintext
+	if last {
+		r.finished = true
+	}
+	return nil
+}
+
+// writer implements the corresponding chunked encryption side of the format.
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	counter uint64
+	buf     []byte
+	closed  bool
+}
+
+// NewWriter wraps w, returning a WriteCloser that seals plaintext into the
+// chunked stream format understood by NewReader. Close must be called to
+// flush the final chunk, even if no data was written.
+func NewWriter(w io.Writer, key crypto.SymKey) (io.WriteCloser, error) {
+	payloadKey, err := derivePayloadKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init chacha20poly1305: %w", err)
+	}
+	return &writer{dst: w, aead: aead}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("stream: write after close")
+	}
+	total := len(p)
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= ChunkSize {
+		if err := w.sealChunk(w.buf[:ChunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[ChunkSize:]
+	}
+	return total, nil
+}
+
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.sealChunk(w.buf, true)
+}
+
+func (w *writer) sealChunk(plaintext []byte, last bool) error {
+	nonce := buildNonce(w.counter, last)
+	ciphertext := w.aead.Seal(nil, nonce, plaintext, nil)
+	if _, err := w.dst.Write(ciphertext); err != nil {
+		return fmt.Errorf("stream: failed to write chunk %d: %w", w.counter, err)
+	}
+	w.counter++
+	return nil
+}
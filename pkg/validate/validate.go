@@ -0,0 +1,195 @@
+// Package validate turns "probably protobuf" into "definitely protobuf".
+//
+// The decryptor's trial-decryption loop needs to tell real plaintext apart
+// from the random-looking bytes a wrong key produces. Checking only the
+// first byte's wire type and field number, as the old heuristic did,
+// passes roughly 3/8 of random inputs. WalkWireFormat instead fully walks
+// the varint-length-prefixed structure to EOF, rejecting any malformed
+// tag, unknown wire type, or length overrun anywhere in the stream. Once a
+// candidate survives that, MatchSchema attempts to unmarshal it against
+// the known top-level Anytype message shapes so callers can report which
+// one matched.
+package validate
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// WalkWireFormat fully walks data as a sequence of protobuf field tags and
+// values to EOF. It returns an error at the first malformed tag, unknown
+// wire type, or length-prefixed value that overruns the buffer; reaching
+// EOF cleanly means data is a well-formed (if not necessarily meaningful)
+// protobuf message.
+func WalkWireFormat(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("validate: empty data")
+	}
+	b := data
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("validate: invalid tag at offset %d: %w", len(data)-len(b), protowire.ParseError(n))
+		}
+		if num < protowire.MinValidNumber {
+			return fmt.Errorf("validate: invalid field number %d at offset %d", num, len(data)-len(b))
+		}
+		b = b[n:]
+		vn := protowire.ConsumeFieldValue(num, typ, b)
+		if vn < 0 {
+			return fmt.Errorf("validate: invalid value for field %d (wire type %d) at offset %d: %w", num, typ, len(data)-len(b), protowire.ParseError(vn))
+		}
+		b = b[vn:]
+	}
+	return nil
+}
+
+// Validate confirms data is a well-formed protobuf message via
+// WalkWireFormat, then reports which known Anytype message schema it best
+// matches, if any. An empty schema with a nil error means data parsed as
+// valid protobuf but didn't match any registered schema.
+func Validate(data []byte) (schema string, err error) {
+	if err := WalkWireFormat(data); err != nil {
+		return "", err
+	}
+	return MatchSchema(data), nil
+}
+
+// MatchSchema tries to unmarshal data against every registered Anytype
+// message descriptor, returning the name of whichever one leaves the
+// least unrecognized wire data behind. proto3 never errors on unknown
+// fields during Unmarshal (they land in GetUnknown()), so surviving
+// WalkWireFormat and unmarshaling cleanly isn't enough to call it a
+// match: a message with every byte sitting in GetUnknown() and nothing
+// in its declared fields would "match" any schema, including on
+// garbage. MatchSchema therefore only accepts a candidate that leaves
+// no unrecognized bytes behind and has at least one declared field
+// actually populated; it returns "" if nothing qualifies.
+func MatchSchema(data []byte) string {
+	for _, name := range schemaNames {
+		msg := dynamicpb.NewMessage(schemas[name])
+		if err := proto.Unmarshal(data, msg); err != nil {
+			continue
+		}
+		if len(msg.GetUnknown()) != 0 {
+			continue
+		}
+		if !hasPopulatedField(msg) {
+			continue
+		}
+		return name
+	}
+	return ""
+}
+
+// hasPopulatedField reports whether msg has at least one of its declared
+// fields actually set, as opposed to unmarshaling "successfully" only
+// because every byte of input landed in GetUnknown().
+func hasPopulatedField(msg protoreflect.Message) bool {
+	populated := false
+	msg.Range(func(protoreflect.FieldDescriptor, protoreflect.Value) bool {
+		populated = true
+		return false
+	})
+	return populated
+}
+
+// field is a minimal description of one top-level protobuf field, enough
+// to build a MessageDescriptor at runtime without a .proto file.
+type field struct {
+	name   string
+	number int32
+	kind   descriptorpb.FieldDescriptorProto_Type
+	repeat bool
+}
+
+// schemas holds the registered Anytype message shapes, built once at
+// package init time. These are approximations of the real anytype-heart
+// .proto definitions (account metadata, space headers, file info and
+// change sets) sufficient to distinguish them from random noise and from
+// each other; they are not a substitute for the canonical .proto files.
+var (
+	schemas     map[string]protoreflect.MessageDescriptor
+	schemaNames []string
+)
+
+func init() {
+	registry := []struct {
+		name   string
+		fields []field
+	}{
+		{
+			name: "AccountMetadata",
+			fields: []field{
+				{"identity", 1, descriptorpb.FieldDescriptorProto_TYPE_BYTES, false},
+				{"identity_signature", 2, descriptorpb.FieldDescriptorProto_TYPE_BYTES, false},
+				{"inter_change_key", 3, descriptorpb.FieldDescriptorProto_TYPE_BYTES, false},
+			},
+		},
+		{
+			name: "SpaceHeader",
+			fields: []field{
+				{"space_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, false},
+				{"identity", 2, descriptorpb.FieldDescriptorProto_TYPE_BYTES, false},
+				{"timestamp", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64, false},
+			},
+		},
+		{
+			name: "FileInfo",
+			fields: []field{
+				{"name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, false},
+				{"size", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, false},
+				{"mime", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, false},
+				{"hash", 4, descriptorpb.FieldDescriptorProto_TYPE_BYTES, false},
+			},
+		},
+		{
+			name: "ChangeSet",
+			fields: []field{
+				{"id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, false},
+				{"previous_ids", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, true},
+				{"changes", 3, descriptorpb.FieldDescriptorProto_TYPE_BYTES, true},
+			},
+		},
+	}
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("anytype_decryptor/validate/known_schemas.proto"),
+		Syntax:  proto.String("proto3"),
+		Package: proto.String("anytype_decryptor.validate"),
+	}
+	for _, msg := range registry {
+		dp := &descriptorpb.DescriptorProto{Name: proto.String(msg.name)}
+		for _, f := range msg.fields {
+			label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+			if f.repeat {
+				label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+			}
+			dp.Field = append(dp.Field, &descriptorpb.FieldDescriptorProto{
+				Name:   proto.String(f.name),
+				Number: proto.Int32(f.number),
+				Type:   f.kind.Enum(),
+				Label:  label.Enum(),
+			})
+		}
+		fdp.MessageType = append(fdp.MessageType, dp)
+	}
+
+	file, err := protodesc.NewFile(fdp, nil)
+	if err != nil {
+		panic(fmt.Sprintf("validate: building known-schema registry: %v", err))
+	}
+
+	schemas = make(map[string]protoreflect.MessageDescriptor, len(registry))
+	for _, msg := range registry {
+		md := file.Messages().ByName(protoreflect.Name(msg.name))
+		schemas[msg.name] = md
+		schemaNames = append(schemaNames, msg.name)
+	}
+}